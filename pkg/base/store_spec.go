@@ -411,6 +411,11 @@ func (ssl StoreSpecList) String() string {
 // root directory. It must not be changed without a proper migration.
 const AuxiliaryDir = "auxiliary"
 
+// CheckpointsDir is the path of the directory holding store checkpoints,
+// relative to the auxiliary dir. 'cockroach debug checkpoints' lists the
+// checkpoints found there.
+const CheckpointsDir = "checkpoints"
+
 // PreventedStartupFile is the filename (relative to 'dir') used for files that
 // can block server startup.
 func PreventedStartupFile(dir string) string {
@@ -11,10 +11,16 @@
 package cli
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/cockroachdb/cockroach/pkg/base"
+	"github.com/cockroachdb/cockroach/pkg/storage"
+	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
@@ -68,3 +74,121 @@ func TestDebugKeysHex(t *testing.T) {
 		t.Fatalf("%q", out)
 	}
 }
+
+func TestDebugCheckpoints(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	baseDir, dirCleanupFn := testutils.TempDir(t)
+	defer dirCleanupFn()
+
+	storePath := filepath.Join(baseDir, "store")
+	out, err := cliTest{}.RunWithCapture("debug checkpoints " + storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "no such file or directory") {
+		t.Fatalf("%q", out)
+	}
+
+	db, err := storage.NewPebble(context.Background(), storage.PebbleConfig{
+		StorageConfig: base.StorageConfig{Dir: storePath},
+		Opts:          storage.DefaultPebbleOptions(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	out, err = cliTest{}.RunWithCapture("debug checkpoints " + storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "no checkpoints in "+storePath) {
+		t.Fatalf("%q", out)
+	}
+
+	checkpointDir := filepath.Join(storePath, base.AuxiliaryDir, base.CheckpointsDir, "r1_at_10")
+	if err := db.CreateCheckpoint(checkpointDir); err != nil {
+		t.Fatal(err)
+	}
+	out, err = cliTest{}.RunWithCapture("debug checkpoints " + storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, checkpointDir+": ") {
+		t.Fatalf("%q", out)
+	}
+}
+
+func TestDebugCheckSSTables(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	defer func(engine enginepb.EngineType) {
+		storage.DefaultStorageEngine = engine
+	}(storage.DefaultStorageEngine)
+	storage.DefaultStorageEngine = enginepb.EngineTypePebble
+
+	baseDir, dirCleanupFn := testutils.TempDir(t)
+	defer dirCleanupFn()
+
+	storePath := filepath.Join(baseDir, "store")
+	db, err := storage.NewPebble(context.Background(), storage.PebbleConfig{
+		StorageConfig: base.StorageConfig{Dir: storePath},
+		Opts:          storage.DefaultPebbleOptions(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(storage.MakeMVCCMetadataKey([]byte("a")), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	for _, args := range []string{"", " --verify-checksums"} {
+		out, err := cliTest{}.RunWithCapture("debug check-sstables " + storePath + args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out, "verified 1 sstables") {
+			t.Fatalf("%q", out)
+		}
+	}
+
+	// Corrupt the first data block. The footer and index blocks remain intact,
+	// so only --verify-checksums notices.
+	ssts, err := filepath.Glob(filepath.Join(storePath, "*.sst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ssts) != 1 {
+		t.Fatalf("expected 1 sstable, but found %s", ssts)
+	}
+	data, err := ioutil.ReadFile(ssts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] ^= 0xff
+	if err := ioutil.WriteFile(ssts[0], data, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cliTest{}.RunWithCapture("debug check-sstables " + storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "verified 1 sstables") {
+		t.Fatalf("%q", out)
+	}
+	out, err = cliTest{}.RunWithCapture("debug check-sstables --verify-checksums " + storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "1 of 1 sstables failed verification") {
+		t.Fatalf("%q", out)
+	}
+}
@@ -921,6 +921,14 @@ is the prefix for range local keys. The hex format takes an encoded MVCCKey.`}
 		Description: "Restrict scan to replicated data.",
 	}
 
+	VerifyChecksums = FlagInfo{
+		Name: "verify-checksums",
+		Description: `
+Read every point key and range tombstone of every sstable to verify the
+checksums of their blocks, then check the ordering of keys across levels.
+Filter blocks are not read.`,
+	}
+
 	GossipInputFile = FlagInfo{
 		Name:      "file",
 		Shorthand: "f",
@@ -316,6 +316,7 @@ var debugCtx struct {
 	printSystemConfig bool
 	maxResults        int
 	decodeAsTableDesc string
+	verifyChecksums   bool
 }
 
 // setDebugContextDefaults set the default values in debugCtx.  This
@@ -332,6 +333,7 @@ func setDebugContextDefaults() {
 	debugCtx.maxResults = 0
 	debugCtx.printSystemConfig = false
 	debugCtx.decodeAsTableDesc = ""
+	debugCtx.verifyChecksums = false
 }
 
 // startCtx captures the command-line arguments for the `start` command.
@@ -17,6 +17,7 @@ import (
 	"encoding/base64"
 	gohex "encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"os"
 	"path/filepath"
@@ -777,6 +778,117 @@ func runDebugSSTables(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// checkSSTablesLargestCount is the number of sstables whose key ranges are
+// printed by 'debug check-sstables'.
+const checkSSTablesLargestCount = 10
+
+var debugCheckSSTablesCmd = &cobra.Command{
+	Use:   "check-sstables <directory>",
+	Short: "verify the sstables referenced by a store's manifest",
+	Long: `
+Verify that every sstable referenced by the manifest of a Pebble store exists
+and has the size recorded in the manifest, and that the sstables in L1 and
+below are ordered and non-overlapping, then print the per-level space usage in
+the same format as 'debug sstables', followed by the key ranges of the largest
+sstables. All missing, truncated or overlapping files are reported. With
+--verify-checksums, the point keys and range tombstones of every sstable are
+read to verify the checksums of their data, index and range deletion blocks,
+and, if every sstable passes, the ordering of keys across levels is checked as
+by 'debug pebble db check'. This can be used to validate a checkpoint
+directory before restoring from it.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: MaybeDecorateGRPCError(runDebugCheckSSTables),
+}
+
+func runDebugCheckSSTables(cmd *cobra.Command, args []string) error {
+	stopper := stop.NewStopper()
+	defer stopper.Stop(context.Background())
+
+	db, err := OpenExistingStore(args[0], stopper, true /* readOnly */)
+	if err != nil {
+		return err
+	}
+	p, ok := db.(*storage.Pebble)
+	if !ok {
+		return errors.Errorf("check-sstables is only supported for Pebble stores")
+	}
+
+	if err := p.CheckSSTables(debugCtx.verifyChecksums); err != nil {
+		return err
+	}
+	sstables := p.GetSSTables()
+	var totalSize int64
+	for i := range sstables {
+		totalSize += sstables[i].Size
+	}
+	fmt.Printf("%s", sstables)
+
+	largest := append(storage.SSTableInfos(nil), sstables...)
+	sort.SliceStable(largest, func(i, j int) bool { return largest[i].Size > largest[j].Size })
+	if len(largest) > checkSSTablesLargestCount {
+		largest = largest[:checkSSTablesLargestCount]
+	}
+	fmt.Printf("largest sstables:\n")
+	for _, t := range largest {
+		fmt.Printf("  L%d %s: %s - %s\n", t.Level, humanizeutil.IBytes(t.Size), t.Start, t.End)
+	}
+	fmt.Printf("verified %d sstables (%s)\n", len(sstables), humanizeutil.IBytes(totalSize))
+	return nil
+}
+
+var debugCheckpointsCmd = &cobra.Command{
+	Use:   "checkpoints <directory>",
+	Short: "list the checkpoints of a store",
+	Long: `
+List the checkpoints kept in the auxiliary directory of a store, such as those
+created when a replica inconsistency is detected, with the number of files and
+the total size of each. The store is not opened, so this can be run while the
+node is stopped or against a copy of its directory. Use 'debug check-sstables'
+on a checkpoint directory to verify its contents.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: MaybeDecorateGRPCError(runDebugCheckpoints),
+}
+
+func runDebugCheckpoints(cmd *cobra.Command, args []string) error {
+	if _, err := os.Stat(args[0]); err != nil {
+		return err
+	}
+	checkpointsDir := filepath.Join(args[0], base.AuxiliaryDir, base.CheckpointsDir)
+	checkpoints, err := ioutil.ReadDir(checkpointsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Printf("no checkpoints in %s\n", args[0])
+			return nil
+		}
+		return err
+	}
+	for _, c := range checkpoints {
+		if !c.IsDir() {
+			continue
+		}
+		var files int
+		var size int64
+		if err := filepath.Walk(filepath.Join(checkpointsDir, c.Name()),
+			func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() {
+					files++
+					size += info.Size()
+				}
+				return nil
+			}); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d files, %s\n", filepath.Join(checkpointsDir, c.Name()), files,
+			humanizeutil.IBytes(size))
+	}
+	return nil
+}
+
 var debugGossipValuesCmd = &cobra.Command{
 	Use:   "gossip-values",
 	Short: "dump all the values in a node's gossip instance",
@@ -1228,6 +1340,7 @@ func runDebugMergeLogs(cmd *cobra.Command, args []string) error {
 // and need encryption flags (injected by CCL code).
 // Note: do NOT include commands that just call rocksdb code without setting up an engine.
 var DebugCmdsForRocksDB = []*cobra.Command{
+	debugCheckSSTablesCmd,
 	debugCheckStoreCmd,
 	debugCompactCmd,
 	debugGCCmd,
@@ -1241,6 +1354,7 @@ var DebugCmdsForRocksDB = []*cobra.Command{
 // All other debug commands go here.
 var debugCmds = append(DebugCmdsForRocksDB,
 	debugBallastCmd,
+	debugCheckpointsCmd,
 	debugDecodeKeyCmd,
 	debugDecodeValueCmd,
 	debugDecodeProtoCmd,
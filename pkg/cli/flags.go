@@ -786,6 +786,10 @@ func init() {
 		boolFlag(f, &debugCtx.sizes, cliflags.Sizes)
 		stringFlag(f, &debugCtx.decodeAsTableDesc, cliflags.DecodeAsTable)
 	}
+	{
+		f := debugCheckSSTablesCmd.Flags()
+		boolFlag(f, &debugCtx.verifyChecksums, cliflags.VerifyChecksums)
+	}
 	{
 		f := debugRangeDataCmd.Flags()
 		boolFlag(f, &debugCtx.replicated, cliflags.Replicated)
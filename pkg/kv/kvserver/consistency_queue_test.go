@@ -373,7 +373,7 @@ func TestCheckConsistencyInconsistent(t *testing.T) {
 		if pErr != nil {
 			t.Fatal(pErr)
 		}
-		pat := filepath.Join(testStore.Engine().GetAuxiliaryDir(), "checkpoints") + "/*"
+		pat := filepath.Join(testStore.Engine().GetAuxiliaryDir(), base.CheckpointsDir) + "/*"
 		m, err := filepath.Glob(pat)
 		assert.NoError(t, err)
 		return m
@@ -397,6 +397,30 @@ var (
 		Measurement: "Storage",
 		Unit:        metric.Unit_BYTES,
 	}
+	metaRdbPendingDeletions = metric.Metadata{
+		Name:        "rocksdb.pending-deletions",
+		Help:        "Number of obsolete SSTables queued for paced deletion",
+		Measurement: "SSTables",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaRdbPendingDeletionBytes = metric.Metadata{
+		Name:        "rocksdb.pending-deletion-bytes",
+		Help:        "Bytes of obsolete SSTables queued for paced deletion",
+		Measurement: "Storage",
+		Unit:        metric.Unit_BYTES,
+	}
+	metaRdbArchivedFiles = metric.Metadata{
+		Name:        "rocksdb.archived-files",
+		Help:        "Number of obsolete files kept in the archive",
+		Measurement: "Files",
+		Unit:        metric.Unit_COUNT,
+	}
+	metaRdbArchivedBytes = metric.Metadata{
+		Name:        "rocksdb.archived-bytes",
+		Help:        "Bytes of obsolete files kept in the archive",
+		Measurement: "Storage",
+		Unit:        metric.Unit_BYTES,
+	}
 
 	// Range event metrics.
 	metaRangeSplits = metric.Metadata{
@@ -1070,6 +1094,10 @@ type StoreMetrics struct {
 	RdbReadAmplification        *metric.Gauge
 	RdbNumSSTables              *metric.Gauge
 	RdbPendingCompaction        *metric.Gauge
+	RdbPendingDeletions         *metric.Gauge
+	RdbPendingDeletionBytes     *metric.Gauge
+	RdbArchivedFiles            *metric.Gauge
+	RdbArchivedBytes            *metric.Gauge
 
 	// TODO(mrtracy): This should be removed as part of #4465. This is only
 	// maintained to keep the current structure of NodeStatus; it would be
@@ -1434,6 +1462,10 @@ func newStoreMetrics(histogramWindow time.Duration) *StoreMetrics {
 		RdbReadAmplification:        metric.NewGauge(metaRdbReadAmplification),
 		RdbNumSSTables:              metric.NewGauge(metaRdbNumSSTables),
 		RdbPendingCompaction:        metric.NewGauge(metaRdbPendingCompaction),
+		RdbPendingDeletions:         metric.NewGauge(metaRdbPendingDeletions),
+		RdbPendingDeletionBytes:     metric.NewGauge(metaRdbPendingDeletionBytes),
+		RdbArchivedFiles:            metric.NewGauge(metaRdbArchivedFiles),
+		RdbArchivedBytes:            metric.NewGauge(metaRdbArchivedBytes),
 
 		// Range event metrics.
 		RangeSplits:                  metric.NewCounter(metaRangeSplits),
@@ -1624,6 +1656,10 @@ func (sm *StoreMetrics) updateRocksDBStats(stats storage.Stats) {
 	sm.RdbCompactedBytesRead.Update(stats.CompactedBytesRead)
 	sm.RdbCompactedBytesWritten.Update(stats.CompactedBytesWritten)
 	sm.RdbTableReadersMemEstimate.Update(stats.TableReadersMemEstimate)
+	sm.RdbPendingDeletions.Update(stats.PendingDeletions)
+	sm.RdbPendingDeletionBytes.Update(stats.PendingDeletionBytes)
+	sm.RdbArchivedFiles.Update(stats.ArchivedFiles)
+	sm.RdbArchivedBytes.Update(stats.ArchivedBytes)
 }
 
 func (sm *StoreMetrics) updateEnvStats(stats storage.EnvStats) {
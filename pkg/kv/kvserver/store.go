@@ -2585,7 +2585,7 @@ func (s *Store) updateReplicationGauges(ctx context.Context) error {
 // provided tag used in the filepath. The filepath for the checkpoint directory
 // is returned.
 func (s *Store) checkpoint(ctx context.Context, tag string) (string, error) {
-	checkpointBase := filepath.Join(s.engine.GetAuxiliaryDir(), "checkpoints")
+	checkpointBase := filepath.Join(s.engine.GetAuxiliaryDir(), base.CheckpointsDir)
 	_ = s.engine.MkdirAll(checkpointBase)
 
 	checkpointDir := filepath.Join(checkpointBase, tag)
@@ -157,8 +157,8 @@ type MVCCIterator interface {
 type IterOptions struct {
 	// If Prefix is true, Seek will use the user-key prefix of
 	// the supplied MVCC key to restrict which sstables are searched,
-	// but iteration (using Next) over keys without the same user-key
-	// prefix will not work correctly (keys may be skipped).
+	// and iteration (using Next) stops once the iterator moves past the
+	// keys with the same user-key prefix.
 	Prefix bool
 	// LowerBound gives this iterator an inclusive lower bound. Attempts to
 	// SeekReverse or Prev to a key that is strictly less than the bound will
@@ -507,6 +507,10 @@ type Stats struct {
 	PendingCompactionBytesEstimate int64
 	L0FileCount                    int64
 	L0SublevelCount                int64
+	PendingDeletions               int64 // Pebble only
+	PendingDeletionBytes           int64 // Pebble only
+	ArchivedFiles                  int64 // Pebble only
+	ArchivedBytes                  int64 // Pebble only
 }
 
 // EnvStats is a set of RocksDB env stats, including encryption status.
@@ -709,14 +713,17 @@ var ingestDelayTime = settings.RegisterDurationSetting(
 )
 
 // PreIngestDelay may choose to block for some duration if L0 has an excessive
-// number of files in it or if PendingCompactionBytesEstimate is elevated. This
-// it is intended to be called before ingesting a new SST, since we'd rather
-// backpressure the bulk operation adding SSTs than slow down the whole RocksDB
-// instance and impact all forground traffic by adding too many files to it.
-// After the number of L0 files exceeds the configured limit, it gradually
-// begins delaying more for each additional file in L0 over the limit until
-// hitting its configured (via settings) maximum delay. If the pending
-// compaction limit is exceeded, it waits for the maximum delay.
+// number of files or sublevels in it. It is intended to be called before
+// ingesting a new SST, since we'd rather backpressure the bulk operation adding
+// SSTs than slow down the whole RocksDB instance and impact all forground
+// traffic by adding too many files to it. After the L0 read amplification
+// (sublevels for Pebble, files for RocksDB) exceeds the configured limit, it
+// gradually begins delaying more for each additional file in L0 over the limit
+// until hitting its configured (via settings) maximum delay.
+//
+// Pending compaction debt is not considered: the
+// rocksdb.ingest_backpressure.pending_compaction_threshold setting was
+// retired in 20.2.
 func preIngestDelay(ctx context.Context, eng Engine, settings *cluster.Settings) {
 	if settings == nil {
 		return
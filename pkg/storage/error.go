@@ -10,6 +10,12 @@
 
 package storage
 
+import (
+	"syscall"
+
+	"github.com/cockroachdb/errors"
+)
+
 // A Error wraps an error returned from a RocksDB operation.
 type Error struct {
 	msg string
@@ -19,3 +25,12 @@ type Error struct {
 func (err *Error) Error() string {
 	return err.msg
 }
+
+// IsDiskFull returns true if err was caused by the store running out of disk
+// space, either because the filesystem returned ENOSPC or because a Pebble
+// engine's PebbleConfig.MaxDiskUsage was exceeded. Unlike corruption, this is
+// a condition that may be resolved by freeing up space, so callers can use it
+// to decide whether to retry rather than treat the error as fatal.
+func IsDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, ErrDiskUsageExceeded)
+}
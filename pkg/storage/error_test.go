@@ -0,0 +1,41 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storage
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/errors"
+)
+
+func TestIsDiskFull(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	testCases := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{errors.New("boom"), false},
+		{syscall.ENOSPC, true},
+		{errors.Wrap(&os.PathError{Op: "write", Path: "000001.log", Err: syscall.ENOSPC}, "writing WAL"), true},
+		{errors.Wrap(&os.PathError{Op: "write", Path: "000001.log", Err: syscall.EIO}, "writing WAL"), false},
+		{errors.Wrap(ErrDiskUsageExceeded, "using 2.0 GiB of 1.0 GiB"), true},
+	}
+	for _, tc := range testCases {
+		if actual := IsDiskFull(tc.err); actual != tc.expected {
+			t.Errorf("IsDiskFull(%v): expected %t, but found %t", tc.err, tc.expected, actual)
+		}
+	}
+}
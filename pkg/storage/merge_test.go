@@ -21,6 +21,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
 	"github.com/cockroachdb/cockroach/pkg/util/protoutil"
+	"github.com/cockroachdb/errors"
 	"github.com/gogo/protobuf/proto"
 )
 
@@ -181,12 +182,12 @@ func TestGoMergeCorruption(t *testing.T) {
 			t.Errorf("goMerge: %d: expected error", i)
 		}
 		_, err = mergeValuesPebble(false /* reverse */, [][]byte{c.existing, c.update})
-		if err == nil {
-			t.Fatalf("pebble merge forward: %d: expected error", i)
+		if !errors.Is(err, ErrInvalidMergeOperand) {
+			t.Fatalf("pebble merge forward: %d: expected ErrInvalidMergeOperand, got %v", i, err)
 		}
 		_, err = mergeValuesPebble(true /* reverse */, [][]byte{c.existing, c.update})
-		if err == nil {
-			t.Fatalf("pebble merge reverse: %d: expected error", i)
+		if !errors.Is(err, ErrInvalidMergeOperand) {
+			t.Fatalf("pebble merge reverse: %d: expected ErrInvalidMergeOperand, got %v", i, err)
 		}
 	}
 }
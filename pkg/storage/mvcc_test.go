@@ -39,6 +39,7 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/shuffle"
 	"github.com/cockroachdb/cockroach/pkg/util/uuid"
 	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble/vfs"
 	"github.com/gogo/protobuf/proto"
 	"github.com/kr/pretty"
 	"github.com/stretchr/testify/require"
@@ -90,6 +91,18 @@ func createTestPebbleEngine() Engine {
 	return newPebbleInMem(context.Background(), roachpb.Attributes{}, 1<<20)
 }
 
+// openTestPebbleInMem opens a Pebble engine with the default options on fs, or
+// on a fresh in-memory filesystem if fs is nil. Options other than cfg.Opts
+// are taken from cfg.
+func openTestPebbleInMem(fs vfs.FS, cfg PebbleConfig) (*Pebble, error) {
+	if fs == nil {
+		fs = vfs.NewMem()
+	}
+	cfg.Opts = DefaultPebbleOptions()
+	cfg.Opts.FS = fs
+	return NewPebble(context.Background(), cfg)
+}
+
 var mvccEngineImpls = []struct {
 	name   string
 	create func() Engine
@@ -34,6 +34,7 @@ import (
 	"github.com/cockroachdb/logtags"
 	"github.com/cockroachdb/pebble"
 	"github.com/cockroachdb/pebble/bloom"
+	"github.com/cockroachdb/pebble/sstable"
 	"github.com/cockroachdb/pebble/vfs"
 )
 
@@ -376,6 +377,61 @@ type PebbleConfig struct {
 	base.StorageConfig
 	// Pebble specific options.
 	Opts *pebble.Options
+	// MaxDiskUsage, if positive, is the number of bytes used by sstables and
+	// WALs above which writes and ingestion are rejected with
+	// ErrDiskUsageExceeded. Obsolete sstables are counted while they are still
+	// referenced by open iterators, queued by MaxObsoleteDeletionRate or kept
+	// by ArchiveRetention, but not while they wait for pebble to delete them.
+	// WALs are counted by their live data, which is less than their on-disk
+	// size when WAL files are recycled. Flushes, compactions and file
+	// deletions continue, so writes are accepted again once the usage drops
+	// below the limit. Deletions issued directly against the engine (Clear,
+	// SingleClear and ClearRange), and batches that only contain deletions,
+	// are always accepted.
+	//
+	// The limit must not be enabled for stores that apply raft commands: an
+	// error from committing a raft application batch is treated as a
+	// non-deterministic failure and is fatal to the node.
+	MaxDiskUsage int64
+	// MaxObsoleteDeletionRate, if positive, is the number of bytes per second
+	// at which obsolete sstables are deleted. Deletions are queued and
+	// performed by a background goroutine, so that removing the inputs of a
+	// large compaction does not saturate the disk. Sstables still queued when
+	// the engine is closed are deleted without pacing.
+	MaxObsoleteDeletionRate int64
+	// ArchiveRetention, if either of its limits is set, archives obsolete
+	// files instead of deleting them and prunes the archive to the limits.
+	// It overrides Opts.Cleaner. pebble.ArchiveCleaner needs the contents of
+	// obsolete files, so pebble stops recycling WAL files while it is set.
+	// The archive time of each file is only kept in memory: files are aged
+	// from when the store was last opened, so a store that is reopened more
+	// often than MaxAge never expires archived files by age.
+	ArchiveRetention ArchiveRetention
+	// ParanoidChecks, if set, runs CheckSSTables when the store is opened and
+	// fails the open if any sstable referenced by the manifest is missing,
+	// truncated, unreadable or misplaced. This reads the footer of every
+	// sstable, so it slows down opening large stores.
+	ParanoidChecks bool
+	// FailOnWriteStall, if set, causes writes to be rejected with
+	// ErrWriteStall while pebble is stalling writes (for example because too
+	// many memtables are queued for flushing) instead of blocking until the
+	// stall clears. This lets callers shed load.
+	//
+	// Like MaxDiskUsage, it must not be enabled for stores that apply raft
+	// commands: a stall that rejects the commit of a raft application batch
+	// is treated as a non-deterministic failure and is fatal to the node.
+	FailOnWriteStall bool
+	// MaxKeySize, MaxValueSize and MaxBatchSize, if positive, are the
+	// largest key, value and batch representation in bytes that the engine
+	// accepts. Larger writes are rejected with ErrKeyTooLarge,
+	// ErrValueTooLarge and ErrBatchTooLarge respectively, protecting the store
+	// from application bugs that write huge values.
+	MaxKeySize   int64
+	MaxValueSize int64
+	MaxBatchSize int64
+	// IOLimits throttles the WAL and sstable I/O performed through the
+	// store's filesystem. See NewRateLimitedFS.
+	IOLimits IOLimits
 }
 
 // EncryptionStatsHandler provides encryption related stats.
@@ -404,6 +460,13 @@ type Pebble struct {
 	settings     *cluster.Settings
 	statsHandler EncryptionStatsHandler
 	fileRegistry *PebbleFileRegistry
+	diskUsage    *diskUsageLimiter
+	writeStall   *writeStallMonitor
+	sizeLimits   *writeSizeLimits
+	// deletionPacer is nil unless PebbleConfig.MaxObsoleteDeletionRate is set.
+	deletionPacer *deletionPacer
+	// archivePruner is nil unless PebbleConfig.ArchiveRetention is set.
+	archivePruner *archivePruner
 
 	// Relevant options copied over from pebble.Options.
 	fs     vfs.FS
@@ -478,6 +541,9 @@ func NewPebble(ctx context.Context, cfg PebbleConfig) (*Pebble, error) {
 	if err != nil {
 		return nil, err
 	}
+	if cfg.IOLimits != (IOLimits{}) {
+		cfg.Opts.FS = NewRateLimitedFS(cfg.Opts.FS, cfg.IOLimits)
+	}
 
 	// The context dance here is done so that we have a clean context without
 	// timeouts that has a copy of the log tags.
@@ -490,24 +556,52 @@ func NewPebble(ctx context.Context, cfg PebbleConfig) (*Pebble, error) {
 		ctx:   logCtx,
 		depth: 2, // skip over the EventListener stack frame
 	})
+	writeStall := newWriteStallMonitor(&cfg.Opts.EventListener, cfg.FailOnWriteStall)
+	var pacer *deletionPacer
+	if cfg.MaxObsoleteDeletionRate > 0 && !cfg.Opts.ReadOnly {
+		pacer = newDeletionPacer(cfg.Opts.FS, cfg.Dir, cfg.MaxObsoleteDeletionRate, cfg.Opts.Logger)
+		cfg.Opts.FS = pacer
+	}
+	var pruner *archivePruner
+	if r := cfg.ArchiveRetention; (r.MaxAge > 0 || r.MaxBytes > 0) && !cfg.Opts.ReadOnly {
+		cfg.Opts.Cleaner = pebble.ArchiveCleaner{}
+		pruner = newArchivePruner(cfg.Opts.FS, cfg.Dir, r, cfg.Opts.Logger)
+	}
 
 	db, err := pebble.Open(cfg.StorageConfig.Dir, cfg.Opts)
 	if err != nil {
+		pacer.close()
 		return nil, err
 	}
 
-	return &Pebble{
-		db:           db,
-		path:         cfg.Dir,
-		auxDir:       auxDir,
-		maxSize:      cfg.MaxSize,
-		attrs:        cfg.Attrs,
-		settings:     cfg.Settings,
-		statsHandler: statsHandler,
-		fileRegistry: fileRegistry,
-		fs:           cfg.Opts.FS,
-		logger:       cfg.Opts.Logger,
-	}, nil
+	p := &Pebble{
+		db:            db,
+		path:          cfg.Dir,
+		auxDir:        auxDir,
+		maxSize:       cfg.MaxSize,
+		attrs:         cfg.Attrs,
+		settings:      cfg.Settings,
+		statsHandler:  statsHandler,
+		fileRegistry:  fileRegistry,
+		diskUsage:     newDiskUsageLimiter(db, pacer, pruner, cfg.MaxDiskUsage),
+		writeStall:    writeStall,
+		sizeLimits:    newWriteSizeLimits(cfg),
+		deletionPacer: pacer,
+		archivePruner: pruner,
+		fs:            cfg.Opts.FS,
+		logger:        cfg.Opts.Logger,
+	}
+
+	if cfg.ParanoidChecks {
+		if err := p.CheckSSTables(false /* verifyChecksums */); err != nil {
+			p.Close()
+			return nil, errors.Wrapf(err, "paranoid checks failed for store at %s", cfg.Dir)
+		}
+	}
+	if pruner != nil {
+		pruner.start()
+	}
+	return p, nil
 }
 
 func newTeeInMem(ctx context.Context, attrs roachpb.Attributes, cacheSize int64) *TeeEngine {
@@ -563,6 +657,8 @@ func (p *Pebble) Close() {
 	}
 	p.closed = true
 	_ = p.db.Close()
+	p.deletionPacer.close()
+	p.archivePruner.close()
 }
 
 // Closed implements the Engine interface.
@@ -649,6 +745,15 @@ func (p *Pebble) NewIterator(opts IterOptions) Iterator {
 
 // ApplyBatchRepr implements the Engine interface.
 func (p *Pebble) ApplyBatchRepr(repr []byte, sync bool) error {
+	if err := p.sizeLimits.checkBatchRepr(repr); err != nil {
+		return err
+	}
+	if err := p.diskUsage.checkBatch(repr); err != nil {
+		return err
+	}
+	if err := p.writeStall.check(); err != nil {
+		return err
+	}
 	// batch.SetRepr takes ownership of the underlying slice, so make a copy.
 	reprCopy := make([]byte, len(repr))
 	copy(reprCopy, repr)
@@ -705,6 +810,15 @@ func (p *Pebble) Merge(key MVCCKey, value []byte) error {
 	if len(key.Key) == 0 {
 		return emptyKeyError()
 	}
+	if err := p.sizeLimits.checkKeyValue(key, value); err != nil {
+		return err
+	}
+	if err := p.diskUsage.check(); err != nil {
+		return err
+	}
+	if err := p.writeStall.check(); err != nil {
+		return err
+	}
 	return p.db.Merge(EncodeKey(key), value, pebble.Sync)
 }
 
@@ -713,11 +827,26 @@ func (p *Pebble) Put(key MVCCKey, value []byte) error {
 	if len(key.Key) == 0 {
 		return emptyKeyError()
 	}
+	if err := p.sizeLimits.checkKeyValue(key, value); err != nil {
+		return err
+	}
+	if err := p.diskUsage.check(); err != nil {
+		return err
+	}
+	if err := p.writeStall.check(); err != nil {
+		return err
+	}
 	return p.db.Set(EncodeKey(key), value, pebble.Sync)
 }
 
 // LogData implements the Engine interface.
 func (p *Pebble) LogData(data []byte) error {
+	if err := p.diskUsage.check(); err != nil {
+		return err
+	}
+	if err := p.writeStall.check(); err != nil {
+		return err
+	}
 	return p.db.LogData(data, pebble.Sync)
 }
 
@@ -744,6 +873,8 @@ func (p *Pebble) Flush() error {
 // GetStats implements the Engine interface.
 func (p *Pebble) GetStats() (*Stats, error) {
 	m := p.db.Metrics()
+	pendingDeletions, pendingDeletionBytes := p.deletionPacer.pending()
+	archivedFiles, archivedBytes := p.archivePruner.archived()
 
 	// Aggregate compaction stats across levels.
 	var ingestedBytes, compactedBytesRead, compactedBytesWritten int64
@@ -771,6 +902,10 @@ func (p *Pebble) GetStats() (*Stats, error) {
 		PendingCompactionBytesEstimate: int64(m.Compact.EstimatedDebt),
 		L0FileCount:                    m.Levels[0].NumFiles,
 		L0SublevelCount:                int64(m.Levels[0].Sublevels),
+		PendingDeletions:               pendingDeletions,
+		PendingDeletionBytes:           pendingDeletionBytes,
+		ArchivedFiles:                  archivedFiles,
+		ArchivedBytes:                  archivedBytes,
 	}, nil
 }
 
@@ -863,7 +998,11 @@ func (p *Pebble) GetAuxiliaryDir() string {
 
 // NewBatch implements the Engine interface.
 func (p *Pebble) NewBatch() Batch {
-	return newPebbleBatch(p.db, p.db.NewIndexedBatch())
+	b := newPebbleBatch(p.db, p.db.NewIndexedBatch())
+	b.diskUsage = p.diskUsage
+	b.writeStall = p.writeStall
+	b.sizeLimits = p.sizeLimits
+	return b
 }
 
 // NewReadOnly implements the Engine interface.
@@ -875,7 +1014,11 @@ func (p *Pebble) NewReadOnly() ReadWriter {
 
 // NewWriteOnlyBatch implements the Engine interface.
 func (p *Pebble) NewWriteOnlyBatch() Batch {
-	return newPebbleBatch(p.db, p.db.NewBatch())
+	b := newPebbleBatch(p.db, p.db.NewBatch())
+	b.diskUsage = p.diskUsage
+	b.writeStall = p.writeStall
+	b.sizeLimits = p.sizeLimits
+	return b
 }
 
 // NewSnapshot implements the Engine interface.
@@ -892,6 +1035,9 @@ func (p *Pebble) Type() enginepb.EngineType {
 
 // IngestExternalFiles implements the Engine interface.
 func (p *Pebble) IngestExternalFiles(ctx context.Context, paths []string) error {
+	if err := p.diskUsage.check(); err != nil {
+		return err
+	}
 	return p.db.Ingest(paths)
 }
 
@@ -1043,6 +1189,229 @@ func (p *Pebble) GetSSTables() (sstables SSTableInfos) {
 	return sstables
 }
 
+// CheckSSTables verifies that every sstable in the current version of the LSM
+// exists on disk with the size recorded in the manifest and a readable footer
+// and properties block, and that the level invariants hold: every sstable's
+// smallest key sorts at or before its largest key, and the sstables in L1 and
+// below are ordered and non-overlapping. Every missing, mismatched, corrupt or
+// misplaced file is described in the returned error.
+//
+// If verifyChecksums is set, the point keys and range tombstones of every
+// sstable are also read, which verifies the checksums of their data, index and
+// range deletion blocks; filter blocks are not read. If every sstable passes,
+// the store is then checked with Verify, which also covers the memtables and
+// the ordering of keys across levels.
+func (p *Pebble) CheckSSTables(verifyChecksums bool) error {
+	var problems []string
+	var count, failed int
+	for level, tables := range p.db.SSTables() {
+		for i, table := range tables {
+			count++
+			n := len(problems)
+			filename := fmt.Sprintf("%06d.sst", table.FileNum)
+			if MVCCComparer.Compare(table.Smallest.UserKey, table.Largest.UserKey) > 0 {
+				problems = append(problems, fmt.Sprintf("L%d %s: smallest key %s sorts after largest key %s",
+					level, filename, table.Smallest, table.Largest))
+			}
+			if level > 0 && i > 0 &&
+				MVCCComparer.Compare(tables[i-1].Largest.UserKey, table.Smallest.UserKey) > 0 {
+				problems = append(problems, fmt.Sprintf("L%d %s: overlaps %06d.sst",
+					level, filename, tables[i-1].FileNum))
+			}
+			if err := p.checkSSTableFile(filename, table.Size, verifyChecksums); err != nil {
+				problems = append(problems, fmt.Sprintf("L%d %s: %v", level, filename, err))
+			}
+			if len(problems) > n {
+				failed++
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("%d of %d sstables failed verification:\n%s",
+			failed, count, strings.Join(problems, "\n"))
+	}
+	if verifyChecksums {
+		return p.Verify()
+	}
+	return nil
+}
+
+// checkSSTableFile verifies that the named sstable has the expected size and
+// that its footer and properties block can be read. If verifyChecksums is set,
+// its point keys and range tombstones are read as well.
+func (p *Pebble) checkSSTableFile(filename string, size uint64, verifyChecksums bool) error {
+	path := p.fs.PathJoin(p.path, filename)
+	info, err := p.fs.Stat(path)
+	if err != nil {
+		return err
+	}
+	if actual := uint64(info.Size()); actual != size {
+		return errors.Errorf("size %d does not match manifest size %d", actual, size)
+	}
+	sst, err := p.openSSTable(filename)
+	if err != nil {
+		return err
+	}
+	if verifyChecksums {
+		if err := scanSSTable(sst); err != nil {
+			_ = sst.Close()
+			return err
+		}
+	}
+	return sst.Close()
+}
+
+// openSSTable opens the named sstable in the store directory for reading.
+func (p *Pebble) openSSTable(filename string) (*sstable.Reader, error) {
+	file, err := p.fs.Open(p.fs.PathJoin(p.path, filename))
+	if err != nil {
+		return nil, err
+	}
+	return sstable.NewReader(file, sstable.ReaderOptions{
+		Comparer:   MVCCComparer,
+		MergerName: MVCCMerger.Name,
+	})
+}
+
+// scanSSTable reads every point key and range tombstone of the sstable, which
+// verifies the checksum of each data block, index block and range deletion
+// block along the way.
+func scanSSTable(sst *sstable.Reader) error {
+	iter, err := sst.NewIter(nil /* lower */, nil /* upper */)
+	if err != nil {
+		return err
+	}
+	if err := scanInternalIterator(iter); err != nil {
+		return err
+	}
+	rangeDelIter, err := sst.NewRawRangeDelIter()
+	if err != nil || rangeDelIter == nil {
+		return err
+	}
+	return scanInternalIterator(rangeDelIter)
+}
+
+// internalIterator is the subset of pebble's internal iterator interface used
+// to scan the blocks of an sstable.
+type internalIterator interface {
+	First() (*sstable.InternalKey, []byte)
+	Next() (*sstable.InternalKey, []byte)
+	Error() error
+	Close() error
+}
+
+// scanInternalIterator reads every entry of iter and closes it.
+func scanInternalIterator(iter internalIterator) error {
+	for key, _ := iter.First(); key != nil; key, _ = iter.Next() {
+	}
+	if err := iter.Error(); err != nil {
+		_ = iter.Close()
+		return err
+	}
+	return iter.Close()
+}
+
+// ClearRangeEstimate describes the expected impact of deleting a key span
+// with a range tombstone.
+type ClearRangeEstimate struct {
+	// ShadowedBytes is an estimate of the on-disk bytes within the span, all
+	// of which become garbage once the tombstone is compacted.
+	ShadowedBytes uint64
+	// ShadowedKeys is an estimate of the number of keys within the span,
+	// counting every version of a key and every tombstone already written.
+	// It is taken from the entry counts in the properties of the overlapping
+	// sstables, prorated by the fraction of each sstable's data blocks that
+	// the span covers for sstables that are only partially covered. Keys in
+	// the memtables are not counted.
+	ShadowedKeys uint64
+	// OverlappingSSTables is the number of sstables whose bounds overlap the
+	// span.
+	OverlappingSSTables int
+	// CompactionBytes is the total size of the overlapping sstables. It is an
+	// upper bound on the bytes compactions must rewrite to drop the shadowed
+	// data.
+	CompactionBytes uint64
+}
+
+// EstimateClearRangeImpact estimates how much data a ClearRange over [start,
+// end) would shadow and how much compaction work it would induce, so that
+// callers can pace mass deletions.
+func (p *Pebble) EstimateClearRangeImpact(start, end roachpb.Key) (ClearRangeEstimate, error) {
+	bufStart := EncodeKey(MVCCKey{start, hlc.Timestamp{}})
+	bufEnd := EncodeKey(MVCCKey{end, hlc.Timestamp{}})
+
+	var estimate ClearRangeEstimate
+	var err error
+	if estimate.ShadowedBytes, err = p.db.EstimateDiskUsage(bufStart, bufEnd); err != nil {
+		return ClearRangeEstimate{}, err
+	}
+	for _, tables := range p.db.SSTables() {
+		for _, table := range tables {
+			if MVCCComparer.Compare(table.Largest.UserKey, bufStart) < 0 ||
+				MVCCComparer.Compare(table.Smallest.UserKey, bufEnd) >= 0 {
+				continue
+			}
+			estimate.OverlappingSSTables++
+			estimate.CompactionBytes += table.Size
+			contained := MVCCComparer.Compare(table.Smallest.UserKey, bufStart) >= 0 &&
+				MVCCComparer.Compare(table.Largest.UserKey, bufEnd) < 0
+			keys, err := p.estimateSSTableKeys(table.FileNum, bufStart, bufEnd, contained)
+			if err != nil {
+				return ClearRangeEstimate{}, err
+			}
+			estimate.ShadowedKeys += keys
+		}
+	}
+	return estimate, nil
+}
+
+// estimateSSTableKeys estimates the number of entries in the sstable that lie
+// within [start, end). If contained is set, the sstable lies entirely within
+// the span and all of its entries are counted.
+func (p *Pebble) estimateSSTableKeys(
+	fileNum pebble.FileNum, start, end []byte, contained bool,
+) (uint64, error) {
+	sst, err := p.openSSTable(fmt.Sprintf("%06d.sst", fileNum))
+	if err != nil {
+		return 0, err
+	}
+	props := sst.Properties
+	keys := props.NumEntries
+	if !contained && props.DataSize > 0 {
+		var covered uint64
+		if covered, err = sst.EstimateDiskUsage(start, end); err != nil {
+			_ = sst.Close()
+			return 0, err
+		}
+		if covered < props.DataSize {
+			keys = uint64(float64(keys) * float64(covered) / float64(props.DataSize))
+		}
+	}
+	return keys, sst.Close()
+}
+
+// Verify checks the consistency of the open store. It reads every point key
+// and range tombstone in the memtables and sstables, which verifies the
+// checksum of every block it reads, and checks that the keys and range
+// tombstones within each sstable are ordered, that a newer version of a key
+// is never found below an older one in the LSM, that no range tombstone
+// deletes a newer key, and that all merge operands can be merged. The
+// returned error describes the first violation found, including the level
+// and file number of the sstables involved.
+//
+// Verify is built on pebble.DB.CheckLevels, which accepts neither key bounds
+// nor a read rate. It therefore always reads the whole store as fast as it
+// can, competing with foreground traffic on a production node; callers that
+// need to bound its impact must throttle the store's filesystem.
+func (p *Pebble) Verify() error {
+	var stats pebble.CheckLevelsStats
+	if err := p.db.CheckLevels(&stats); err != nil {
+		return errors.Wrapf(err, "verification of %d points and %d tombstones failed",
+			stats.NumPoints, stats.NumTombstones)
+	}
+	return nil
+}
+
 type pebbleReadOnly struct {
 	parent     *Pebble
 	prefixIter pebbleIterator
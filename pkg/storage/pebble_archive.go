@@ -0,0 +1,196 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storage
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+// ArchiveRetention configures how long obsolete files are kept once they are
+// archived. If either limit is positive, the store uses pebble's
+// ArchiveCleaner, which moves obsolete sstables, WALs and manifests into an
+// "archive" subdirectory of the store directory instead of deleting them, and
+// a background goroutine deletes archived files that exceed the limits,
+// oldest first. A limit of zero or less is not enforced.
+//
+// Archived files are moved rather than deleted, so
+// PebbleConfig.MaxObsoleteDeletionRate does not apply to them.
+type ArchiveRetention struct {
+	// MaxAge is how long a file is kept after it is archived. The age of a
+	// file is measured from when the pruner first finds it in the archive,
+	// since archiving a file by renaming it does not change its modification
+	// time. These times are not persisted, so files already in the archive
+	// when the store is opened are aged from that point.
+	MaxAge time.Duration
+	// MaxBytes is the total size of the archived files above which the oldest
+	// are deleted.
+	MaxBytes int64
+}
+
+// archivePruneInterval is how often the archive is checked against the
+// retention limits.
+const archivePruneInterval = time.Minute
+
+// archivePruner deletes archived files that exceed an ArchiveRetention.
+type archivePruner struct {
+	fs        vfs.FS
+	dir       string
+	retention ArchiveRetention
+	logger    pebble.Logger
+
+	cancel func()
+	doneCh chan struct{}
+
+	// firstSeen records when each archived file was first found. It is only
+	// accessed by prune.
+	firstSeen map[string]time.Time
+
+	mu struct {
+		syncutil.Mutex
+		files int64
+		bytes int64
+	}
+}
+
+// archiveDir returns the directory that pebble's ArchiveCleaner moves the
+// obsolete files of the store in dir to.
+func archiveDir(fs vfs.FS, dir string) string {
+	return fs.PathJoin(dir, "archive")
+}
+
+func newArchivePruner(
+	fs vfs.FS, dir string, retention ArchiveRetention, logger pebble.Logger,
+) *archivePruner {
+	return &archivePruner{
+		fs:        fs,
+		dir:       archiveDir(fs, dir),
+		retention: retention,
+		logger:    logger,
+		firstSeen: make(map[string]time.Time),
+	}
+}
+
+// start prunes the archive every archivePruneInterval until close is called.
+func (a *archivePruner) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	a.doneCh = make(chan struct{})
+	go func() {
+		defer close(a.doneCh)
+		ticker := time.NewTicker(archivePruneInterval)
+		defer ticker.Stop()
+		for {
+			a.prune(timeutil.Now())
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// close stops the background goroutine. A nil receiver is a no-op.
+func (a *archivePruner) close() {
+	if a == nil || a.cancel == nil {
+		return
+	}
+	a.cancel()
+	<-a.doneCh
+}
+
+type archivedFile struct {
+	name string
+	size int64
+	seen time.Time
+}
+
+// prune deletes the archived files that exceed the retention limits as of
+// now, and records the number and size of the files that remain.
+func (a *archivePruner) prune(now time.Time) {
+	names, err := a.fs.List(a.dir)
+	if err != nil && !os.IsNotExist(err) {
+		a.logger.Infof("failed to list archive %s: %v", a.dir, err)
+		return
+	}
+
+	files := make([]archivedFile, 0, len(names))
+	present := make(map[string]struct{}, len(names))
+	var total int64
+	for _, name := range names {
+		path := a.fs.PathJoin(a.dir, name)
+		info, err := a.fs.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		seen, ok := a.firstSeen[name]
+		if !ok {
+			seen = now
+			a.firstSeen[name] = seen
+		}
+		present[name] = struct{}{}
+		files = append(files, archivedFile{name: name, size: info.Size(), seen: seen})
+		total += info.Size()
+	}
+	for name := range a.firstSeen {
+		if _, ok := present[name]; !ok {
+			delete(a.firstSeen, name)
+		}
+	}
+
+	// Pebble file numbers increase monotonically, so files archived during the
+	// same pass are ordered by name.
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].seen.Equal(files[j].seen) {
+			return files[i].seen.Before(files[j].seen)
+		}
+		return files[i].name < files[j].name
+	})
+	for len(files) > 0 {
+		f := files[0]
+		expired := a.retention.MaxAge > 0 && now.Sub(f.seen) > a.retention.MaxAge
+		overLimit := a.retention.MaxBytes > 0 && total > a.retention.MaxBytes
+		if !expired && !overLimit {
+			break
+		}
+		if err := a.fs.Remove(a.fs.PathJoin(a.dir, f.name)); err != nil {
+			a.logger.Infof("failed to delete archived file %s: %v", f.name, err)
+			break
+		}
+		delete(a.firstSeen, f.name)
+		files = files[1:]
+		total -= f.size
+	}
+
+	a.mu.Lock()
+	a.mu.files = int64(len(files))
+	a.mu.bytes = total
+	a.mu.Unlock()
+}
+
+// archived returns the number and total size of the archived files as of the
+// last prune.
+func (a *archivePruner) archived() (files int64, bytes int64) {
+	if a == nil {
+		return 0, 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.mu.files, a.mu.bytes
+}
@@ -31,6 +31,13 @@ type pebbleBatch struct {
 	isDistinct   bool
 	distinctOpen bool
 	parentBatch  *pebbleBatch
+	// If non-nil, Commit is rejected when the engine's disk usage limit has
+	// been exceeded.
+	diskUsage *diskUsageLimiter
+	// If non-nil, Commit is rejected while the engine is stalling writes.
+	writeStall *writeStallMonitor
+	// If non-nil, oversized keys, values and batches are rejected.
+	sizeLimits *writeSizeLimits
 }
 
 var _ Batch = &pebbleBatch{}
@@ -218,6 +225,9 @@ func (p *pebbleBatch) ApplyBatchRepr(repr []byte, sync bool) error {
 	if p.distinctOpen {
 		panic("distinct batch open")
 	}
+	if err := p.sizeLimits.checkBatchRepr(repr); err != nil {
+		return err
+	}
 
 	var batch pebble.Batch
 	if err := batch.SetRepr(repr); err != nil {
@@ -303,6 +313,9 @@ func (p *pebbleBatch) Merge(key MVCCKey, value []byte) error {
 	if len(key.Key) == 0 {
 		return emptyKeyError()
 	}
+	if err := p.sizeLimits.checkKeyValue(key, value); err != nil {
+		return err
+	}
 
 	p.buf = EncodeKeyToBuf(p.buf[:0], key)
 	return p.batch.Merge(p.buf, value, nil)
@@ -316,6 +329,9 @@ func (p *pebbleBatch) Put(key MVCCKey, value []byte) error {
 	if len(key.Key) == 0 {
 		return emptyKeyError()
 	}
+	if err := p.sizeLimits.checkKeyValue(key, value); err != nil {
+		return err
+	}
 
 	p.buf = EncodeKeyToBuf(p.buf[:0], key)
 	return p.batch.Set(p.buf, value, nil)
@@ -339,6 +355,15 @@ func (p *pebbleBatch) Commit(sync bool) error {
 	if p.batch == nil {
 		panic("called with nil batch")
 	}
+	if err := p.diskUsage.checkBatch(p.batch.Repr()); err != nil {
+		return err
+	}
+	if err := p.writeStall.check(); err != nil {
+		return err
+	}
+	if err := p.sizeLimits.checkBatch(p.Len()); err != nil {
+		return err
+	}
 	err := p.batch.Commit(opts)
 	if err != nil {
 		panic(err)
@@ -365,6 +390,7 @@ func (p *pebbleBatch) Distinct() ReadWriter {
 	d := newPebbleBatch(p.db, p.batch)
 	d.parentBatch = p
 	d.isDistinct = true
+	d.sizeLimits = p.sizeLimits
 	return d
 }
 
@@ -0,0 +1,181 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storage
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/util/limit"
+	"github.com/cockroachdb/cockroach/pkg/util/syncutil"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+	"golang.org/x/time/rate"
+)
+
+// deletionPacer is a vfs.FS that defers the removal of the sstables in a
+// store directory to a background goroutine, which deletes them at a bounded
+// number of bytes per second. This smooths out the burst of deletions that
+// follows a large compaction.
+//
+// Pacing happens at the filesystem rather than in a pebble.Cleaner because
+// the Cleaner interface is defined in terms of a file type that is internal
+// to pebble. Pebble's DeleteCleaner removes files through the configured
+// filesystem, so the pacer sees every obsolete sstable. Only files named like
+// pebble sstables directly inside the store directory are paced: their file
+// numbers are never reused, so deferring their removal cannot race with the
+// creation of a new file of the same name. All other removals happen
+// immediately.
+type deletionPacer struct {
+	vfs.FS
+	dir     string
+	logger  pebble.Logger
+	limiter *limit.LimiterBurstDisabled
+
+	cancel func()
+	wakeCh chan struct{}
+	doneCh chan struct{}
+
+	mu struct {
+		syncutil.Mutex
+		queue []pendingDeletion
+		bytes int64
+	}
+}
+
+type pendingDeletion struct {
+	path string
+	size int64
+}
+
+// newDeletionPacer returns a pacer removing the sstables in dir at no more
+// than bytesPerSec, and starts its background goroutine. The goroutine runs
+// until close is called.
+func newDeletionPacer(
+	fs vfs.FS, dir string, bytesPerSec int64, logger pebble.Logger,
+) *deletionPacer {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &deletionPacer{
+		FS:      fs,
+		dir:     dir,
+		logger:  logger,
+		limiter: limit.NewLimiter(rate.Limit(bytesPerSec)),
+		cancel:  cancel,
+		wakeCh:  make(chan struct{}, 1),
+		doneCh:  make(chan struct{}),
+	}
+	go d.run(ctx)
+	return d
+}
+
+// isPacedTable returns true if name is an sstable directly inside the store
+// directory.
+func (d *deletionPacer) isPacedTable(name string) bool {
+	base := d.PathBase(name)
+	if d.PathJoin(d.dir, base) != name || !strings.HasSuffix(base, ".sst") {
+		return false
+	}
+	_, err := strconv.ParseUint(strings.TrimSuffix(base, ".sst"), 10, 64)
+	return err == nil
+}
+
+// Remove implements vfs.FS.Remove. Sstables in the store directory are queued
+// for deletion and Remove returns immediately.
+func (d *deletionPacer) Remove(name string) error {
+	if !d.isPacedTable(name) {
+		return d.FS.Remove(name)
+	}
+	info, err := d.Stat(name)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.mu.queue = append(d.mu.queue, pendingDeletion{path: name, size: info.Size()})
+	d.mu.bytes += info.Size()
+	d.mu.Unlock()
+
+	select {
+	case d.wakeCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pending returns the number and total size of the sstables awaiting
+// deletion.
+func (d *deletionPacer) pending() (count int64, bytes int64) {
+	if d == nil {
+		return 0, 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return int64(len(d.mu.queue)), d.mu.bytes
+}
+
+// next returns the oldest queued deletion, if any, without dequeuing it.
+func (d *deletionPacer) next() (pendingDeletion, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.mu.queue) == 0 {
+		return pendingDeletion{}, false
+	}
+	return d.mu.queue[0], true
+}
+
+// remove deletes the oldest queued sstable and dequeues it.
+func (d *deletionPacer) remove(del pendingDeletion) {
+	if err := d.FS.Remove(del.path); err != nil {
+		d.logger.Infof("failed to delete obsolete sstable %s: %v", del.path, err)
+	}
+	d.mu.Lock()
+	d.mu.queue = d.mu.queue[1:]
+	d.mu.bytes -= del.size
+	d.mu.Unlock()
+}
+
+func (d *deletionPacer) run(ctx context.Context) {
+	defer close(d.doneCh)
+	for {
+		del, ok := d.next()
+		if !ok {
+			select {
+			case <-d.wakeCh:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		// WaitN only fails if the context is canceled, in which case close
+		// removes the remaining files.
+		if err := d.limiter.WaitN(ctx, int(del.size)); err != nil {
+			return
+		}
+		d.remove(del)
+	}
+}
+
+// close stops the background goroutine and removes any sstables still queued
+// without pacing them. A nil receiver is a no-op.
+func (d *deletionPacer) close() {
+	if d == nil {
+		return
+	}
+	d.cancel()
+	<-d.doneCh
+	for {
+		del, ok := d.next()
+		if !ok {
+			return
+		}
+		d.remove(del)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble"
+)
+
+// ErrDiskUsageExceeded is returned by writes to a Pebble engine whose on-disk
+// footprint exceeds PebbleConfig.MaxDiskUsage.
+var ErrDiskUsageExceeded = errors.New("disk usage limit exceeded")
+
+// diskUsageRefreshInterval is how long a computed disk usage is cached before
+// it is recomputed. pebble.DB.Metrics acquires the DB mutex, which is too
+// expensive to do on every write.
+const diskUsageRefreshInterval = time.Second
+
+// pebbleDiskUsage returns the number of bytes used by live sstables, by zombie
+// sstables that are still referenced by iterators, and by the live data in WAL
+// files. The latter is less than the on-disk size of the WAL files when they
+// are recycled. Obsolete files that pebble has handed to the deletion pacer or
+// moved to the archive are no longer in its metrics, so the bytes still queued
+// or archived are added separately. The archived bytes are only as recent as
+// the last prune of the archive.
+func pebbleDiskUsage(m *pebble.Metrics, pacer *deletionPacer, pruner *archivePruner) uint64 {
+	usage := m.WAL.Size + m.Table.ZombieSize
+	for _, l := range m.Levels {
+		usage += uint64(l.Size)
+	}
+	_, pendingBytes := pacer.pending()
+	_, archivedBytes := pruner.archived()
+	return usage + uint64(pendingBytes) + uint64(archivedBytes)
+}
+
+// diskUsageLimiter rejects foreground writes once the on-disk footprint of a
+// pebble.DB exceeds a limit. Flushes, compactions and the deletion of
+// obsolete files are unaffected, so writes are accepted again as soon as
+// background work brings the usage back under the limit.
+type diskUsageLimiter struct {
+	db     *pebble.DB
+	pacer  *deletionPacer
+	pruner *archivePruner
+	limit  uint64
+
+	// usage and nextRefresh are accessed atomically. nextRefresh is the
+	// UnixNano time after which usage is considered stale.
+	usage       uint64
+	nextRefresh int64
+}
+
+func newDiskUsageLimiter(
+	db *pebble.DB, pacer *deletionPacer, pruner *archivePruner, limit int64,
+) *diskUsageLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &diskUsageLimiter{db: db, pacer: pacer, pruner: pruner, limit: uint64(limit)}
+}
+
+// check returns an error wrapping ErrDiskUsageExceeded if the disk usage is
+// above the limit. A nil receiver never returns an error.
+func (l *diskUsageLimiter) check() error {
+	if l == nil {
+		return nil
+	}
+	now := timeutil.Now().UnixNano()
+	next := atomic.LoadInt64(&l.nextRefresh)
+	if now > next && atomic.CompareAndSwapInt64(&l.nextRefresh, next, now+int64(diskUsageRefreshInterval)) {
+		atomic.StoreUint64(&l.usage, pebbleDiskUsage(l.db.Metrics(), l.pacer, l.pruner))
+	}
+	if usage := atomic.LoadUint64(&l.usage); usage > l.limit {
+		return errors.Wrapf(ErrDiskUsageExceeded, "using %s of %s",
+			humanizeutil.IBytes(int64(usage)), humanizeutil.IBytes(int64(l.limit)))
+	}
+	return nil
+}
+
+// checkBatch is like check, but always accepts a batch representation that
+// only contains point and range deletions, so that space can be reclaimed
+// while the usage is above the limit. The batch is only decoded if the usage
+// is above the limit.
+func (l *diskUsageLimiter) checkBatch(repr []byte) error {
+	if err := l.check(); err != nil && !isDeletionOnlyBatch(repr) {
+		return err
+	}
+	return nil
+}
+
+// isDeletionOnlyBatch returns true if the batch representation is well formed
+// and only contains point and range deletions.
+func isDeletionOnlyBatch(repr []byte) bool {
+	r, err := NewRocksDBBatchReader(repr)
+	if err != nil {
+		return false
+	}
+	for r.Next() {
+		switch r.BatchType() {
+		case BatchTypeDeletion, BatchTypeSingleDeletion, BatchTypeRangeDeletion:
+		default:
+			return false
+		}
+	}
+	return r.Error() == nil
+}
@@ -178,6 +178,18 @@ type MVCCValueMerger struct {
 	meta enginepb.MVCCMetadata
 }
 
+// ErrInvalidMergeOperand marks the errors returned by MVCCValueMerger when an
+// operand cannot be decoded or when operands of incompatible value types are
+// merged. Pebble surfaces merge errors from Get and from iteration, and the
+// marker allows callers to tell malformed operands apart from other failures
+// using errors.Is.
+var ErrInvalidMergeOperand = errors.New("invalid merge operand")
+
+// invalidMergeOperandf returns an error marked with ErrInvalidMergeOperand.
+func invalidMergeOperandf(format string, args ...interface{}) error {
+	return errors.Mark(errors.Errorf(format, args...), ErrInvalidMergeOperand)
+}
+
 const (
 	mvccChecksumSize = 4
 	mvccTagPos       = mvccChecksumSize
@@ -202,23 +214,23 @@ func (t *MVCCValueMerger) ensureOrder(oldToNew bool) {
 
 func (t *MVCCValueMerger) deserializeMVCCValueAndAppend(value []byte) error {
 	if err := protoutil.Unmarshal(value, &t.meta); err != nil {
-		return errors.Errorf("corrupted operand value: %v", err)
+		return invalidMergeOperandf("corrupted operand value: %v", err)
 	}
 	if len(t.meta.RawBytes) < mvccHeaderSize {
-		return errors.Errorf("operand value too short")
+		return invalidMergeOperandf("operand value too short")
 	}
 	if t.meta.RawBytes[mvccTagPos] == byte(roachpb.ValueType_TIMESERIES) {
 		if t.rawByteOps != nil {
-			return errors.Errorf("inconsistent value types for timeseries merge")
+			return invalidMergeOperandf("inconsistent value types for timeseries merge")
 		}
 		t.timeSeriesOps = append(t.timeSeriesOps, roachpb.InternalTimeSeriesData{})
 		ts := &t.timeSeriesOps[len(t.timeSeriesOps)-1]
 		if err := protoutil.Unmarshal(t.meta.RawBytes[mvccHeaderSize:], ts); err != nil {
-			return errors.Errorf("corrupted timeseries: %v", err)
+			return invalidMergeOperandf("corrupted timeseries: %v", err)
 		}
 	} else {
 		if t.timeSeriesOps != nil {
-			return errors.Errorf("inconsistent value types for non-timeseries merge")
+			return invalidMergeOperandf("inconsistent value types for non-timeseries merge")
 		}
 		t.rawByteOps = append(t.rawByteOps, t.meta.RawBytes[mvccHeaderSize:])
 	}
@@ -292,10 +304,10 @@ func (t *MVCCValueMerger) Finish(includesBase bool) ([]byte, io.Closer, error) {
 	merged.SampleDurationNanos = t.timeSeriesOps[0].SampleDurationNanos
 	for _, timeSeriesOp := range t.timeSeriesOps {
 		if timeSeriesOp.StartTimestampNanos != merged.StartTimestampNanos {
-			return nil, nil, errors.Errorf("start timestamp mismatch")
+			return nil, nil, invalidMergeOperandf("start timestamp mismatch")
 		}
 		if timeSeriesOp.SampleDurationNanos != merged.SampleDurationNanos {
-			return nil, nil, errors.Errorf("sample duration mismatch")
+			return nil, nil, invalidMergeOperandf("sample duration mismatch")
 		}
 		if !isColumnar && len(timeSeriesOp.Offset) > 0 {
 			ensureColumnar(&merged)
@@ -0,0 +1,109 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storage
+
+import (
+	"github.com/cockroachdb/cockroach/pkg/util/humanizeutil"
+	"github.com/cockroachdb/errors"
+)
+
+var (
+	// ErrKeyTooLarge is returned when writing a key longer than
+	// PebbleConfig.MaxKeySize.
+	ErrKeyTooLarge = errors.New("key too large")
+	// ErrValueTooLarge is returned when writing a value longer than
+	// PebbleConfig.MaxValueSize.
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrBatchTooLarge is returned when committing a batch whose
+	// representation is longer than PebbleConfig.MaxBatchSize.
+	ErrBatchTooLarge = errors.New("batch too large")
+)
+
+// writeSizeLimits rejects keys, values and batches above the configured
+// sizes. A limit of zero or less is not enforced.
+type writeSizeLimits struct {
+	maxKeySize   int64
+	maxValueSize int64
+	maxBatchSize int64
+}
+
+func newWriteSizeLimits(cfg PebbleConfig) *writeSizeLimits {
+	if cfg.MaxKeySize <= 0 && cfg.MaxValueSize <= 0 && cfg.MaxBatchSize <= 0 {
+		return nil
+	}
+	return &writeSizeLimits{
+		maxKeySize:   cfg.MaxKeySize,
+		maxValueSize: cfg.MaxValueSize,
+		maxBatchSize: cfg.MaxBatchSize,
+	}
+}
+
+// checkKeyValue returns an error wrapping ErrKeyTooLarge or ErrValueTooLarge
+// if the key or value exceeds its limit. A nil receiver never returns an
+// error.
+func (l *writeSizeLimits) checkKeyValue(key MVCCKey, value []byte) error {
+	if l == nil {
+		return nil
+	}
+	if l.maxKeySize > 0 && int64(len(key.Key)) > l.maxKeySize {
+		return errors.Wrapf(ErrKeyTooLarge, "key of %s exceeds limit of %s",
+			humanizeutil.IBytes(int64(len(key.Key))), humanizeutil.IBytes(l.maxKeySize))
+	}
+	if l.maxValueSize > 0 && int64(len(value)) > l.maxValueSize {
+		return errors.Wrapf(ErrValueTooLarge, "value of %s for key %s exceeds limit of %s",
+			humanizeutil.IBytes(int64(len(value))), key.Key, humanizeutil.IBytes(l.maxValueSize))
+	}
+	return nil
+}
+
+// checkBatch returns an error wrapping ErrBatchTooLarge if a batch
+// representation of the given length exceeds the limit. A nil receiver never
+// returns an error.
+func (l *writeSizeLimits) checkBatch(size int) error {
+	if l == nil || l.maxBatchSize <= 0 || int64(size) <= l.maxBatchSize {
+		return nil
+	}
+	return errors.Wrapf(ErrBatchTooLarge, "batch of %s exceeds limit of %s",
+		humanizeutil.IBytes(int64(size)), humanizeutil.IBytes(l.maxBatchSize))
+}
+
+// checkBatchRepr applies checkBatch to the length of a batch representation
+// and checkKeyValue to each of its Put and Merge entries, so that batches
+// applied through ApplyBatchRepr are held to the same limits as writes made
+// through Put and Merge. A nil receiver never returns an error.
+func (l *writeSizeLimits) checkBatchRepr(repr []byte) error {
+	if l == nil {
+		return nil
+	}
+	if err := l.checkBatch(len(repr)); err != nil {
+		return err
+	}
+	if l.maxKeySize <= 0 && l.maxValueSize <= 0 {
+		return nil
+	}
+	r, err := NewRocksDBBatchReader(repr)
+	if err != nil {
+		return err
+	}
+	for r.Next() {
+		switch r.BatchType() {
+		case BatchTypeValue, BatchTypeMerge:
+			key, err := r.MVCCKey()
+			if err != nil {
+				return err
+			}
+			if err := l.checkKeyValue(key, r.Value()); err != nil {
+				return err
+			}
+		}
+	}
+	return r.Error()
+}
@@ -12,15 +12,20 @@ package storage
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/cockroachdb/cockroach/pkg/roachpb"
 	"github.com/cockroachdb/cockroach/pkg/storage/enginepb"
+	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/util/hlc"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 	"github.com/cockroachdb/cockroach/pkg/util/log"
@@ -28,7 +33,9 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/util/randutil"
 	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
 	"github.com/cockroachdb/datadriven"
+	"github.com/cockroachdb/errors"
 	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
 )
 
 func TestPebbleTimeBoundPropCollector(t *testing.T) {
@@ -176,10 +183,582 @@ func TestPebbleIterReuse(t *testing.T) {
 	iter2.Close()
 }
 
+func TestPebbleIterPrefix(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	eng := createTestPebbleEngine()
+	defer eng.Close()
+
+	for _, k := range []MVCCKey{
+		{Key: []byte("a"), Timestamp: hlc.Timestamp{WallTime: 2}},
+		{Key: []byte("a"), Timestamp: hlc.Timestamp{WallTime: 1}},
+		{Key: []byte("a\x00")},
+		{Key: []byte("b"), Timestamp: hlc.Timestamp{WallTime: 1}},
+	} {
+		if err := eng.Put(k, []byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A prefix iterator must not return keys beyond the prefix of the seek
+	// key. pebble.Iterator stops once the Split prefix changes after
+	// SeekPrefixGE, matching RocksDB's prefix_same_as_start.
+	iter := eng.NewIterator(IterOptions{Prefix: true})
+	defer iter.Close()
+	for _, tc := range []struct {
+		seekKey  string
+		expected []int64
+	}{
+		{"a", []int64{2, 1}},
+		{"a\x00", []int64{0}},
+		{"b", []int64{1}},
+		{"c", nil},
+	} {
+		var found []int64
+		for iter.SeekGE(makeMVCCKey(tc.seekKey)); ; iter.Next() {
+			ok, err := iter.Valid()
+			if err != nil {
+				t.Fatal(err)
+			} else if !ok {
+				break
+			}
+			key := iter.UnsafeKey()
+			if string(key.Key) != tc.seekKey {
+				t.Fatalf("seek %q: iterator returned key %s outside of prefix", tc.seekKey, key)
+			}
+			found = append(found, key.Timestamp.WallTime)
+		}
+		if fmt.Sprint(found) != fmt.Sprint(tc.expected) {
+			t.Fatalf("seek %q: expected %d, but found %d", tc.seekKey, tc.expected, found)
+		}
+	}
+}
+
+func TestPebbleMaxDiskUsage(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	p, err := openTestPebbleInMem(nil /* fs */, PebbleConfig{MaxDiskUsage: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	key := makeMVCCKey("a")
+	if err := p.Put(key, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	// Force the cached disk usage to be recomputed.
+	atomic.StoreInt64(&p.diskUsage.nextRefresh, 0)
+
+	if err := p.Put(key, []byte("bar")); !errors.Is(err, ErrDiskUsageExceeded) {
+		t.Fatalf("expected ErrDiskUsageExceeded, but found %v", err)
+	} else if !IsDiskFull(err) {
+		t.Fatalf("expected IsDiskFull(%v) to be true", err)
+	}
+	batch := p.NewWriteOnlyBatch()
+	defer batch.Close()
+	if err := batch.Put(key, []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Commit(false /* sync */); !errors.Is(err, ErrDiskUsageExceeded) {
+		t.Fatalf("expected ErrDiskUsageExceeded, but found %v", err)
+	}
+	// Ingestion is rejected before the files are looked at.
+	if err := p.IngestExternalFiles(context.Background(), []string{"ingest"}); !errors.Is(err, ErrDiskUsageExceeded) {
+		t.Fatalf("expected ErrDiskUsageExceeded, but found %v", err)
+	}
+	// Deletions are still accepted, including batches that only delete.
+	if err := p.Clear(key); err != nil {
+		t.Fatal(err)
+	}
+	deletes := p.NewWriteOnlyBatch()
+	defer deletes.Close()
+	if err := deletes.SingleClear(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := deletes.ClearRange(makeMVCCKey("a"), makeMVCCKey("b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ApplyBatchRepr(deletes.Repr(), false /* sync */); err != nil {
+		t.Fatal(err)
+	}
+	if err := deletes.Commit(false /* sync */); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ApplyBatchRepr(batch.Repr(), false /* sync */); !errors.Is(err, ErrDiskUsageExceeded) {
+		t.Fatalf("expected ErrDiskUsageExceeded, but found %v", err)
+	}
+}
+
+func TestPebbleCheckSSTables(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	p := newPebbleInMem(context.Background(), roachpb.Attributes{}, 1<<20)
+	defer p.Close()
+
+	// The sstable holds a range tombstone as well as a point key, so that its
+	// range deletion block is read too.
+	if err := p.Put(makeMVCCKey("a"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ClearRange(makeMVCCKey("b"), makeMVCCKey("c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CheckSSTables(true /* verifyChecksums */); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := p.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var removed bool
+	for _, f := range files {
+		if strings.HasSuffix(f, ".sst") {
+			if err := p.Remove(f); err != nil {
+				t.Fatal(err)
+			}
+			removed = true
+		}
+	}
+	if !removed {
+		t.Fatalf("no sstable found in %s", files)
+	}
+	if err := p.CheckSSTables(true /* verifyChecksums */); !testutils.IsError(err, "1 of 1 sstables failed verification") {
+		t.Fatalf("expected verification failure, but found %v", err)
+	}
+}
+
+func TestPebbleFailOnWriteStall(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	var l pebble.EventListener
+	var stalls int
+	l.WriteStallBegin = func(pebble.WriteStallBeginInfo) { stalls++ }
+	m := newWriteStallMonitor(&l, true /* enabled */)
+	l.WriteStallBegin(pebble.WriteStallBeginInfo{Reason: "memtable count limit reached"})
+	if err := m.check(); !errors.Is(err, ErrWriteStall) {
+		t.Fatalf("expected ErrWriteStall, but found %v", err)
+	} else if !testutils.IsError(err, "memtable count limit reached") {
+		t.Fatalf("expected stall reason in error, but found %v", err)
+	}
+	if stalls != 1 {
+		t.Fatalf("expected the existing listener to be called once, but found %d", stalls)
+	}
+	l.WriteStallEnd()
+	if err := m.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := openTestPebbleInMem(nil /* fs */, PebbleConfig{FailOnWriteStall: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	key := makeMVCCKey("a")
+	atomic.StoreInt32(&p.writeStall.stalled, 1)
+	if err := p.Put(key, []byte("foo")); !errors.Is(err, ErrWriteStall) {
+		t.Fatalf("expected ErrWriteStall, but found %v", err)
+	}
+	batch := p.NewWriteOnlyBatch()
+	defer batch.Close()
+	if err := batch.Put(key, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Commit(false /* sync */); !errors.Is(err, ErrWriteStall) {
+		t.Fatalf("expected ErrWriteStall, but found %v", err)
+	}
+	atomic.StoreInt32(&p.writeStall.stalled, 0)
+	if err := batch.Commit(false /* sync */); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPebbleWriteSizeLimits(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	p, err := openTestPebbleInMem(nil /* fs */, PebbleConfig{
+		MaxKeySize:   4,
+		MaxValueSize: 4,
+		MaxBatchSize: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if err := p.Put(makeMVCCKey("a"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(makeMVCCKey("abcde"), []byte("foo")); !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("expected ErrKeyTooLarge, but found %v", err)
+	}
+	if err := p.Merge(makeMVCCKey("a"), []byte("abcde")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, but found %v", err)
+	}
+
+	batch := p.NewBatch()
+	defer batch.Close()
+	if err := batch.Put(makeMVCCKey("abcde"), []byte("foo")); !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("expected ErrKeyTooLarge, but found %v", err)
+	}
+	if err := batch.Put(makeMVCCKey("a"), []byte("abcde")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, but found %v", err)
+	}
+	for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if err := batch.Put(makeMVCCKey(k), []byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Commit(false /* sync */); !errors.Is(err, ErrBatchTooLarge) {
+		t.Fatalf("expected ErrBatchTooLarge, but found %v", err)
+	}
+	if err := p.ApplyBatchRepr(batch.Repr(), false /* sync */); !errors.Is(err, ErrBatchTooLarge) {
+		t.Fatalf("expected ErrBatchTooLarge, but found %v", err)
+	}
+
+	// Batch representations built elsewhere, such as those applied by raft,
+	// are checked entry by entry.
+	var builder RocksDBBatchBuilder
+	builder.Put(makeMVCCKey("abcde"), []byte("foo"))
+	repr := builder.Finish()
+	if err := p.ApplyBatchRepr(repr, false /* sync */); !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("expected ErrKeyTooLarge, but found %v", err)
+	}
+	builder.Merge(makeMVCCKey("a"), []byte("abcde"))
+	repr = builder.Finish()
+	batch2 := p.NewBatch()
+	defer batch2.Close()
+	if err := batch2.ApplyBatchRepr(repr, false /* sync */); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, but found %v", err)
+	}
+}
+
+func TestPebbleParanoidChecks(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	mem := vfs.NewMem()
+	open := func() (*Pebble, error) {
+		return openTestPebbleInMem(mem, PebbleConfig{ParanoidChecks: true})
+	}
+
+	p, err := open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(makeMVCCKey("a"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	if p, err = open(); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	files, err := mem.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f, ".sst") {
+			if err := mem.Remove(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := open(); !testutils.IsError(err, "paranoid checks failed") {
+		t.Fatalf("expected paranoid checks to fail, but found %v", err)
+	}
+}
+
+func TestPebbleEstimateClearRangeImpact(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	p := newPebbleInMem(context.Background(), roachpb.Attributes{}, 1<<20)
+	defer p.Close()
+
+	for _, k := range []string{"a", "b"} {
+		if err := p.Put(makeMVCCKey(k), []byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		start, end string
+		sstables   int
+	}{
+		{"a", "b", 1},
+		{"a", "c", 2},
+		{"b", "c", 1},
+		{"c", "d", 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.start+"-"+tc.end, func(t *testing.T) {
+			estimate, err := p.EstimateClearRangeImpact(roachpb.Key(tc.start), roachpb.Key(tc.end))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if estimate.OverlappingSSTables != tc.sstables {
+				t.Fatalf("expected %d overlapping sstables, but found %d",
+					tc.sstables, estimate.OverlappingSSTables)
+			}
+			if (estimate.CompactionBytes > 0) != (tc.sstables > 0) {
+				t.Fatalf("unexpected compaction bytes %d for %d sstables",
+					estimate.CompactionBytes, tc.sstables)
+			}
+			// Each sstable holds a single key.
+			if estimate.ShadowedKeys != uint64(tc.sstables) {
+				t.Fatalf("expected %d shadowed keys, but found %d",
+					tc.sstables, estimate.ShadowedKeys)
+			}
+		})
+	}
+}
+
+func TestPebbleVerify(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	mem := vfs.NewMem()
+	p, err := openTestPebbleInMem(mem, PebbleConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(makeMVCCKey("a"), []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Put(makeMVCCKey("b"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	sstables := p.db.SSTables()[0]
+	if len(sstables) != 1 {
+		t.Fatalf("expected 1 sstable in L0, but found %d", len(sstables))
+	}
+	p.Close()
+
+	// Corrupt the first data block of the sstable and reopen the store.
+	path := fmt.Sprintf("%06d.sst", sstables[0].FileNum)
+	f, err := mem.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data[0] ^= 0xff
+	if f, err = mem.Create(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = openTestPebbleInMem(mem, PebbleConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Verify(); !testutils.IsError(err, "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, but found %v", err)
+	}
+}
+
 func makeMVCCKey(a string) MVCCKey {
 	return MVCCKey{Key: []byte(a)}
 }
 
+func TestPebbleObsoleteDeletionPacing(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	mem := vfs.NewMem()
+	// At 1 byte per second, no sstable is deleted before the engine is closed.
+	p, err := openTestPebbleInMem(mem, PebbleConfig{MaxObsoleteDeletionRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Overlapping sstables, so that compacting them rewrites both.
+	for _, v := range []string{"foo", "bar"} {
+		if err := p.Put(makeMVCCKey("a"), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	testutils.SucceedsSoon(t, func() error {
+		stats, err := p.GetStats()
+		if err != nil {
+			return err
+		}
+		if stats.PendingDeletions != 2 || stats.PendingDeletionBytes == 0 {
+			return errors.Errorf("expected 2 pending deletions, but found %d (%d bytes)",
+				stats.PendingDeletions, stats.PendingDeletionBytes)
+		}
+		return nil
+	})
+	// Queued sstables still count towards the disk usage.
+	m := p.db.Metrics()
+	_, pendingBytes := p.deletionPacer.pending()
+	expected := pebbleDiskUsage(m, nil, nil) + uint64(pendingBytes)
+	if usage := pebbleDiskUsage(m, p.deletionPacer, nil); usage != expected {
+		t.Fatalf("expected disk usage of %d, but found %d", expected, usage)
+	}
+	live := len(p.GetSSTables())
+	p.Close()
+
+	// Closing the engine removes the queued sstables.
+	files, err := mem.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables int
+	for _, f := range files {
+		if strings.HasSuffix(f, ".sst") {
+			tables++
+		}
+	}
+	if tables != live {
+		t.Fatalf("expected %d sstables, but found %d: %s", live, tables, files)
+	}
+}
+
+func TestPebbleArchiveRetention(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	mem := vfs.NewMem()
+	p, err := openTestPebbleInMem(mem, PebbleConfig{
+		ArchiveRetention: ArchiveRetention{MaxAge: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// Overlapping sstables, so that compacting them makes both obsolete.
+	for _, v := range []string{"foo", "bar"} {
+		if err := p.Put(makeMVCCKey("a"), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	testutils.SucceedsSoon(t, func() error {
+		files, err := mem.List("archive")
+		if err != nil {
+			return err
+		}
+		var tables int
+		for _, f := range files {
+			if strings.HasSuffix(f, ".sst") {
+				tables++
+			}
+		}
+		if tables != 2 {
+			return errors.Errorf("expected 2 archived sstables, but found %s", files)
+		}
+		return nil
+	})
+}
+
+func TestArchivePruner(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	mem := vfs.NewMem()
+	if err := mem.MkdirAll("archive", 0755); err != nil {
+		t.Fatal(err)
+	}
+	create := func(name string) {
+		f, err := mem.Create(mem.PathJoin("archive", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(make([]byte, 10)); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := newArchivePruner(mem, "", ArchiveRetention{MaxAge: time.Hour, MaxBytes: 25}, pebble.DefaultLogger)
+	now := timeutil.Now()
+	for _, name := range []string{"000001.sst", "000002.sst", "000003.sst"} {
+		create(name)
+	}
+
+	testCases := []struct {
+		elapsed  time.Duration
+		create   string
+		expected []string
+	}{
+		// The oldest file is deleted to get under MaxBytes.
+		{0, "", []string{"000002.sst", "000003.sst"}},
+		{30 * time.Minute, "000004.sst", []string{"000003.sst", "000004.sst"}},
+		// Files are aged from when they were first found.
+		{61 * time.Minute, "", []string{"000004.sst"}},
+		{89 * time.Minute, "", []string{"000004.sst"}},
+		{91 * time.Minute, "", nil},
+	}
+	for _, tc := range testCases {
+		if tc.create != "" {
+			create(tc.create)
+		}
+		a.prune(now.Add(tc.elapsed))
+		files, err := mem.List("archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(files)
+		if fmt.Sprint(files) != fmt.Sprint(tc.expected) {
+			t.Fatalf("after %s: expected %s, but found %s", tc.elapsed, tc.expected, files)
+		}
+		if count, bytes := a.archived(); count != int64(len(files)) || bytes != 10*count {
+			t.Fatalf("after %s: unexpected stats %d files, %d bytes", tc.elapsed, count, bytes)
+		}
+	}
+}
+
 func TestPebbleSeparatorSuccessor(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 	defer log.Scope(t).Close(t)
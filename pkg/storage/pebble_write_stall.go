@@ -0,0 +1,70 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storage
+
+import (
+	"sync/atomic"
+
+	"github.com/cockroachdb/errors"
+	"github.com/cockroachdb/pebble"
+)
+
+// ErrWriteStall is returned by writes to a Pebble engine configured with
+// PebbleConfig.FailOnWriteStall while pebble is stalling writes.
+var ErrWriteStall = errors.New("writes are stalled")
+
+// writeStallMonitor tracks whether pebble is stalling writes, as reported by
+// the WriteStallBegin and WriteStallEnd events, so that foreground writes can
+// be rejected instead of blocking until the stall clears.
+type writeStallMonitor struct {
+	// stalled is accessed atomically. It is 1 while writes are stalled.
+	stalled int32
+	// reason holds the string reported by the most recent WriteStallBegin
+	// event.
+	reason atomic.Value
+}
+
+// newWriteStallMonitor returns a monitor hooked into the given event listener,
+// or nil if enabled is false. The existing WriteStallBegin and WriteStallEnd
+// callbacks of the listener continue to be invoked.
+func newWriteStallMonitor(l *pebble.EventListener, enabled bool) *writeStallMonitor {
+	if !enabled {
+		return nil
+	}
+	m := &writeStallMonitor{}
+	m.reason.Store("")
+	begin, end := l.WriteStallBegin, l.WriteStallEnd
+	l.WriteStallBegin = func(info pebble.WriteStallBeginInfo) {
+		m.reason.Store(info.Reason)
+		atomic.StoreInt32(&m.stalled, 1)
+		if begin != nil {
+			begin(info)
+		}
+	}
+	l.WriteStallEnd = func() {
+		atomic.StoreInt32(&m.stalled, 0)
+		if end != nil {
+			end()
+		}
+	}
+	return m
+}
+
+// check returns an error wrapping ErrWriteStall if writes are currently
+// stalled. The check is racy: a write that passes it may still block if a
+// stall begins before the write is committed. A nil receiver never returns an
+// error.
+func (m *writeStallMonitor) check() error {
+	if m == nil || atomic.LoadInt32(&m.stalled) == 0 {
+		return nil
+	}
+	return errors.Wrapf(ErrWriteStall, "%s", m.reason.Load().(string))
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cockroachdb/cockroach/pkg/util/limit"
+	"github.com/cockroachdb/pebble/vfs"
+	"golang.org/x/time/rate"
+)
+
+// IOLimits configures the bytes per second allowed for each class of I/O
+// performed by a Pebble store. A limit of zero or less is not enforced.
+//
+// Classes are told apart by file type: pebble does not tell the filesystem
+// which operation an I/O belongs to, so flushes, compactions and ingestion
+// share the sstable write budget, and foreground iterators and compactions
+// share the sstable read budget.
+type IOLimits struct {
+	// WALWriteBytesPerSec limits writes to write-ahead log files.
+	WALWriteBytesPerSec int64
+	// SSTableWriteBytesPerSec limits writes to sstables.
+	SSTableWriteBytesPerSec int64
+	// SSTableReadBytesPerSec limits reads from sstables.
+	SSTableReadBytesPerSec int64
+}
+
+// NewRateLimitedFS wraps fs so that reads and writes of WAL files and sstables
+// are throttled according to limits. Other files, such as the MANIFEST, are
+// never throttled.
+func NewRateLimitedFS(fs vfs.FS, limits IOLimits) vfs.FS {
+	return &rateLimitedFS{
+		FS:       fs,
+		walWrite: newIOLimiter(limits.WALWriteBytesPerSec),
+		sstWrite: newIOLimiter(limits.SSTableWriteBytesPerSec),
+		sstRead:  newIOLimiter(limits.SSTableReadBytesPerSec),
+	}
+}
+
+func newIOLimiter(bytesPerSec int64) *limit.LimiterBurstDisabled {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return limit.NewLimiter(rate.Limit(bytesPerSec))
+}
+
+// rateLimitedFS implements vfs.FS.
+type rateLimitedFS struct {
+	vfs.FS
+	walWrite *limit.LimiterBurstDisabled
+	sstWrite *limit.LimiterBurstDisabled
+	sstRead  *limit.LimiterBurstDisabled
+}
+
+// wrap returns f wrapped with the limiters that apply to the named file, or f
+// itself if none apply.
+func (fs *rateLimitedFS) wrap(name string, f vfs.File) vfs.File {
+	var read, write *limit.LimiterBurstDisabled
+	switch {
+	case strings.HasSuffix(name, ".log"):
+		write = fs.walWrite
+	case strings.HasSuffix(name, ".sst"):
+		read, write = fs.sstRead, fs.sstWrite
+	}
+	if read == nil && write == nil {
+		return f
+	}
+	rf := &rateLimitedFile{File: f, read: read, write: write}
+	if fd, ok := f.(fder); ok {
+		return &rateLimitedFdFile{rateLimitedFile: rf, fd: fd}
+	}
+	return rf
+}
+
+// Create implements vfs.FS.Create.
+func (fs *rateLimitedFS) Create(name string) (vfs.File, error) {
+	f, err := fs.FS.Create(name)
+	if err != nil {
+		return f, err
+	}
+	return fs.wrap(name, f), nil
+}
+
+// Open implements vfs.FS.Open.
+func (fs *rateLimitedFS) Open(name string, opts ...vfs.OpenOption) (vfs.File, error) {
+	f, err := fs.FS.Open(name, opts...)
+	if err != nil {
+		return f, err
+	}
+	return fs.wrap(name, f), nil
+}
+
+// ReuseForWrite implements vfs.FS.ReuseForWrite.
+func (fs *rateLimitedFS) ReuseForWrite(oldname, newname string) (vfs.File, error) {
+	f, err := fs.FS.ReuseForWrite(oldname, newname)
+	if err != nil {
+		return f, err
+	}
+	return fs.wrap(newname, f), nil
+}
+
+// rateLimitedFile implements vfs.File. A nil limiter leaves the corresponding
+// direction unthrottled.
+type rateLimitedFile struct {
+	vfs.File
+	read  *limit.LimiterBurstDisabled
+	write *limit.LimiterBurstDisabled
+}
+
+// Write implements io.Writer.
+func (f *rateLimitedFile) Write(p []byte) (n int, err error) {
+	if f.write != nil {
+		if err := f.write.WaitN(context.Background(), len(p)); err != nil {
+			return 0, err
+		}
+	}
+	return f.File.Write(p)
+}
+
+// Read implements io.Reader.
+func (f *rateLimitedFile) Read(p []byte) (n int, err error) {
+	n, err = f.File.Read(p)
+	f.waitRead(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt.
+func (f *rateLimitedFile) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = f.File.ReadAt(p, off)
+	f.waitRead(n)
+	return n, err
+}
+
+// fder is implemented by files backed by an OS file descriptor. Pebble uses the
+// descriptor, when present, for sync_file_range, fallocate and fadvise.
+type fder interface {
+	Fd() uintptr
+}
+
+// rateLimitedFdFile is a rateLimitedFile that exposes the file descriptor of
+// the wrapped file. It is a separate type so that files without a descriptor
+// do not appear to have one.
+type rateLimitedFdFile struct {
+	*rateLimitedFile
+	fd fder
+}
+
+// Fd returns the file descriptor of the wrapped file.
+func (f *rateLimitedFdFile) Fd() uintptr {
+	return f.fd.Fd()
+}
+
+// waitRead charges n bytes read against the read limiter. Reads are charged
+// after the fact so that short reads at the end of a file are not
+// over-counted.
+func (f *rateLimitedFile) waitRead(n int) {
+	if f.read != nil && n > 0 {
+		// WaitN only fails if the context is canceled.
+		_ = f.read.WaitN(context.Background(), n)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/pkg/testutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+	"github.com/cockroachdb/cockroach/pkg/util/log"
+	"github.com/cockroachdb/cockroach/pkg/util/timeutil"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+func TestRateLimitedFS(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	fs := NewRateLimitedFS(vfs.NewMem(), IOLimits{
+		WALWriteBytesPerSec:     1 << 20,
+		SSTableWriteBytesPerSec: 1 << 20,
+	})
+
+	testCases := []struct {
+		name    string
+		limited bool
+	}{
+		{"000001.log", true},
+		{"000002.sst", true},
+		{"MANIFEST-000003", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := fs.Create(tc.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, limited := f.(*rateLimitedFile); limited != tc.limited {
+				t.Fatalf("expected limited=%t, but found %t", tc.limited, limited)
+			}
+			data := []byte("hello world")
+			if _, err := f.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err = fs.Open(tc.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			got, err := ioutil.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, got) {
+				t.Fatalf("expected %q, but found %q", data, got)
+			}
+		})
+	}
+}
+
+func TestRateLimitedFSThrottles(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	// The limiters start with a full second's worth of tokens, so moving one
+	// and a half seconds' worth of bytes must take at least half a second.
+	const bytesPerSec = 10 << 10
+	const minDuration = 500 * time.Millisecond
+	fs := NewRateLimitedFS(vfs.NewMem(), IOLimits{
+		SSTableWriteBytesPerSec: bytesPerSec,
+		SSTableReadBytesPerSec:  bytesPerSec,
+	})
+	data := make([]byte, bytesPerSec*3/2)
+
+	f, err := fs.Create("000001.sst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := timeutil.Now()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := timeutil.Since(start); elapsed < minDuration {
+		t.Fatalf("expected write to take at least %s, but took %s", minDuration, elapsed)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads are charged against a separate limiter, which is still full.
+	f, err = fs.Open("000001.sst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	start = timeutil.Now()
+	if _, err := f.ReadAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := timeutil.Since(start); elapsed < minDuration {
+		t.Fatalf("expected read to take at least %s, but took %s", minDuration, elapsed)
+	}
+}
+
+func TestRateLimitedFSFd(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	dir, cleanup := testutils.TempDir(t)
+	defer cleanup()
+
+	// Files backed by a descriptor keep exposing it, so that pebble can use
+	// sync_file_range and fallocate on them.
+	fs := NewRateLimitedFS(vfs.Default, IOLimits{SSTableWriteBytesPerSec: 1 << 20})
+	f, err := fs.Create(fs.PathJoin(dir, "000001.sst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fdFile, ok := f.(fder)
+	if !ok {
+		t.Fatalf("expected %T to expose Fd", f)
+	}
+	if osFile := f.(*rateLimitedFdFile).File.(*os.File); fdFile.Fd() != osFile.Fd() {
+		t.Fatalf("expected fd %d, but found %d", osFile.Fd(), fdFile.Fd())
+	}
+
+	// Files without a descriptor do not appear to have one.
+	fs = NewRateLimitedFS(vfs.NewMem(), IOLimits{SSTableWriteBytesPerSec: 1 << 20})
+	if f, err = fs.Create("000001.sst"); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(fder); ok {
+		t.Fatalf("expected %T not to expose Fd", f)
+	}
+}
+
+func TestPebbleIOLimits(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+	defer log.Scope(t).Close(t)
+
+	p, err := openTestPebbleInMem(nil /* fs */, PebbleConfig{
+		IOLimits: IOLimits{
+			WALWriteBytesPerSec:     1 << 20,
+			SSTableWriteBytesPerSec: 1 << 20,
+			SSTableReadBytesPerSec:  1 << 20,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	key := makeMVCCKey("a")
+	if err := p.Put(key, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := p.Get(key); err != nil {
+		t.Fatal(err)
+	} else if string(val) != "foo" {
+		t.Fatalf("expected foo, but found %q", val)
+	}
+}
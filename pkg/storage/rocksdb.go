@@ -936,6 +936,10 @@ func (r *RocksDB) GetStats() (*Stats, error) {
 		PendingCompactionBytesEstimate: int64(s.pending_compaction_bytes_estimate),
 		L0FileCount:                    int64(s.l0_file_count),
 		L0SublevelCount:                -1, // Not a RocksDB feature.
+		PendingDeletions:               0,  // Not a RocksDB feature.
+		PendingDeletionBytes:           0,  // Not a RocksDB feature.
+		ArchivedFiles:                  0,  // Not a RocksDB feature.
+		ArchivedBytes:                  0,  // Not a RocksDB feature.
 	}, nil
 }
 
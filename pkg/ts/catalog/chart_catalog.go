@@ -2060,6 +2060,22 @@ var charts = []sectionDescription{
 				Title:   "Count",
 				Metrics: []string{"rocksdb.num-sstables"},
 			},
+			{
+				Title:   "Pending Deletions",
+				Metrics: []string{"rocksdb.pending-deletions"},
+			},
+			{
+				Title:   "Pending Deletion Size",
+				Metrics: []string{"rocksdb.pending-deletion-bytes"},
+			},
+			{
+				Title:   "Archived Files",
+				Metrics: []string{"rocksdb.archived-files"},
+			},
+			{
+				Title:   "Archive Size",
+				Metrics: []string{"rocksdb.archived-bytes"},
+			},
 			{
 				Title: "Ingestions",
 				Metrics: []string{